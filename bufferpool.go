@@ -0,0 +1,22 @@
+package main
+
+import "sync"
+
+// _DefaultBufferSize matches the size io.Copy would otherwise allocate per
+// call; kept as the default here so pooling doesn't change steady-state
+// memory use, just who pays for the allocation.
+const _DefaultBufferSize = 32 * 1024
+
+// _BufferSize is read by bufferPool.New, so set it (e.g. from the
+// -buffer-size flag) before the first pipe() call if a different size is
+// wanted.
+var _BufferSize = _DefaultBufferSize
+
+// bufferPool recycles the byte slices pipe uses for io.CopyBuffer, so a
+// relay handling millions of connections doesn't pay io.Copy's per-call
+// allocation - and the GC pressure that comes with it - on every copy.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, _BufferSize)
+	},
+}
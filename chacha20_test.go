@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestChaCha20BlockZero checks the first keystream block against RFC 8439
+// Appendix A.1 Test Vector #1 (all-zero key and nonce, block counter 0).
+func TestChaCha20BlockZero(t *testing.T) {
+	key := make([]byte, chacha20KeySize)
+	nonce := make([]byte, chacha20NonceSize)
+
+	s, err := newChaCha20(key, nonce)
+	if err != nil {
+		t.Fatalf("newChaCha20: %v", err)
+	}
+
+	want, err := hex.DecodeString(
+		"76b8e0ada0f13d90405d6ae55386bd28" +
+			"bdd219b8a08ded1aa836efcc8b770dc7" +
+			"da41597c5157488d7724e03fb8d84a37" +
+			"6a43b8f41518a11cc387b669b2ee6586")
+	if err != nil {
+		t.Fatalf("decode expected keystream: %v", err)
+	}
+
+	got := make([]byte, chacha20BlockSize)
+	s.XORKeyStream(got, make([]byte, chacha20BlockSize))
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("keystream mismatch:\ngot  %x\nwant %x", got, want)
+	}
+}
+
+func TestChaCha20RejectsBadSizes(t *testing.T) {
+	if _, err := newChaCha20(make([]byte, 16), make([]byte, chacha20NonceSize)); err == nil {
+		t.Fatal("expected error for short key")
+	}
+	if _, err := newChaCha20(make([]byte, chacha20KeySize), make([]byte, 8)); err == nil {
+		t.Fatal("expected error for short nonce")
+	}
+}
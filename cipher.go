@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+)
+
+// Cipher constructs the stream used to decrypt the address header (and, once
+// OTA/data framing needs it, the data channel). Implementations are
+// registered by name in cipherConstructors so TCPServer/UDPServer can select
+// one at startup via the -cipher flag instead of being locked to AES-CFB.
+type Cipher interface {
+	// Decrypter returns a cipher.Stream that decrypts data encrypted with
+	// key and iv. iv must be IVSize() bytes long.
+	Decrypter(key, iv []byte) (cipher.Stream, error)
+	// IVSize is the length, in bytes, of the IV/nonce this cipher expects
+	// at the front of the address header.
+	IVSize() int
+}
+
+type cipherConstructor func() Cipher
+
+var cipherConstructors = map[string]cipherConstructor{
+	"aes-128-cfb":   func() Cipher { return aesCFBCipher{keySize: 16} },
+	"aes-256-cfb":   func() Cipher { return aesCFBCipher{keySize: 32} },
+	"aes-128-ctr":   func() Cipher { return aesCTRCipher{keySize: 16} },
+	"aes-256-ctr":   func() Cipher { return aesCTRCipher{keySize: 32} },
+	"chacha20-ietf": func() Cipher { return chacha20IETFCipher{} },
+}
+
+// NewCipher looks up a registered cipher by name. The AES variants carry the
+// key size their name promises (16 or 32 bytes) and reject a -secret of any
+// other length, so e.g. -cipher aes-128-ctr can't silently run as AES-256.
+func NewCipher(name string) (Cipher, error) {
+	ctor, ok := cipherConstructors[name]
+	if !ok {
+		return nil, errors.New("unknown cipher: " + name)
+	}
+	return ctor(), nil
+}
+
+type aesCFBCipher struct{ keySize int }
+
+func (aesCFBCipher) IVSize() int { return aes.BlockSize }
+
+func (c aesCFBCipher) Decrypter(key, iv []byte) (cipher.Stream, error) {
+	if len(key) != c.keySize {
+		return nil, fmt.Errorf("aes-cfb: key must be %d bytes, got %d", c.keySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewCFBDecrypter(block, iv), nil
+}
+
+type aesCTRCipher struct{ keySize int }
+
+func (aesCTRCipher) IVSize() int { return aes.BlockSize }
+
+func (c aesCTRCipher) Decrypter(key, iv []byte) (cipher.Stream, error) {
+	if len(key) != c.keySize {
+		return nil, fmt.Errorf("aes-ctr: key must be %d bytes, got %d", c.keySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewCTR(block, iv), nil
+}
+
+type chacha20IETFCipher struct{}
+
+func (chacha20IETFCipher) IVSize() int { return chacha20NonceSize }
+
+func (chacha20IETFCipher) Decrypter(key, iv []byte) (cipher.Stream, error) {
+	return newChaCha20(key, iv)
+}
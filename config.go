@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// ListenerConfig describes one frontd listener: where it binds and the
+// cipher/secret/salt/OTA/UDP settings that apply to connections accepted
+// there. A -config file is a JSON array of these, letting one frontd
+// process front several backends with distinct credentials.
+type ListenerConfig struct {
+	Listen string `json:"listen"`
+	Cipher string `json:"cipher"`
+	Secret string `json:"secret"`
+	Salt   string `json:"salt"`
+	OTA    bool   `json:"ota"`
+	UDP    bool   `json:"udp"`
+}
+
+// loadConfig reads a JSON array of ListenerConfig from path.
+func loadConfig(path string) ([]ListenerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var listeners []ListenerConfig
+	if err := json.Unmarshal(data, &listeners); err != nil {
+		return nil, err
+	}
+
+	return listeners, nil
+}
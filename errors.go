@@ -0,0 +1,15 @@
+package main
+
+import "errors"
+
+// Sentinel errors for the address-header decode path, so callers can log a
+// stable, greppable reason instead of an ad-hoc string and so tests (or
+// future callers) can distinguish failure modes with errors.Is.
+var (
+	ErrShortCiphertext = errors.New("frontd: ciphertext too short")
+	ErrShortHeader     = errors.New("frontd: decrypted header too short for addr and salt")
+	ErrSaltMismatch    = errors.New("frontd: salt does not match")
+	ErrBackendDial     = errors.New("frontd: failed to dial backend")
+	ErrOTARequired     = errors.New("frontd: listener requires OTA but connection did not use it")
+	ErrOTAAuthFailed   = errors.New("frontd: ota authentication failed")
+)
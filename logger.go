@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// logLevel orders the four verbosities frontd logs at, so -log-level can
+// drop anything below the configured floor instead of printing everything.
+type logLevel int
+
+const (
+	logDebug logLevel = iota
+	logInfo
+	logWarn
+	logError
+)
+
+func (l logLevel) String() string {
+	switch l {
+	case logDebug:
+		return "DEBUG"
+	case logInfo:
+		return "INFO"
+	case logWarn:
+		return "WARN"
+	case logError:
+		return "ERROR"
+	default:
+		return "INFO"
+	}
+}
+
+// parseLogLevel maps the -log-level flag value to a logLevel, defaulting to
+// info for anything unrecognized.
+func parseLogLevel(s string) logLevel {
+	switch s {
+	case "debug":
+		return logDebug
+	case "warn":
+		return logWarn
+	case "error":
+		return logError
+	default:
+		return logInfo
+	}
+}
+
+// _MinLogLevel is set from the -log-level flag at startup; logf calls below
+// it are dropped.
+var _MinLogLevel = logInfo
+
+var _Logger = log.New(os.Stderr, "", log.LstdFlags)
+
+func logf(level logLevel, format string, args ...interface{}) {
+	if level < _MinLogLevel {
+		return
+	}
+	_Logger.Output(3, level.String()+" "+fmt.Sprintf(format, args...))
+}
+
+func debugf(format string, args ...interface{}) { logf(logDebug, format, args...) }
+func infof(format string, args ...interface{})  { logf(logInfo, format, args...) }
+func warnf(format string, args ...interface{})  { logf(logWarn, format, args...) }
+func errorf(format string, args ...interface{}) { logf(logError, format, args...) }
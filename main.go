@@ -1,175 +1,91 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"crypto/aes"
-	"crypto/cipher"
-	"encoding/base64"
-	"errors"
+	"flag"
 	"io"
 	"log"
-	"net"
 	"os"
 	"runtime"
 	"runtime/debug"
 	"sync"
-	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 const (
 	// max open file should at least be
-	_MaxOpenfile              uint64 = 1024 * 1024 * 1024
-	_MaxBackendAddrCacheCount int    = 1024 * 1024
-	_DefaultPort                     = "4043"
+	_MaxOpenfile   uint64 = 1024 * 1024 * 1024
+	_DefaultListen        = ":4043"
+	_DefaultCipher        = "aes-128-cfb"
 )
 
-var (
-	_SecretPassphase []byte
-	_Salt            []byte
-)
-
-var (
-	_BackendAddrCacheMutex = new(sync.Mutex)
-	_BackendAddrCache      atomic.Value
-)
-
-func init() {
-	_BackendAddrCache.Store(make(map[string]string))
-}
-
-func readBackendAddrCache(key string) (string, bool) {
-	m1 := _BackendAddrCache.Load().(map[string]string)
-
-	val, ok := m1[key]
-	return val, ok
-}
-
-func writeBackendAddrCache(key, val string) {
-	_BackendAddrCacheMutex.Lock()
-	defer _BackendAddrCacheMutex.Unlock()
-
-	m1 := _BackendAddrCache.Load().(map[string]string)
-	m2 := make(map[string]string) // create a new value
-
-	// flush cache if there is way too many
-	if len(m1) < _MaxBackendAddrCacheCount {
-		// copy-on-write
-		for k, v := range m1 {
-			m2[k] = v // copy all data from the current object to the new one
-		}
-	}
-
-	m2[key] = val
-	_BackendAddrCache.Store(m2) // atomically replace the current object with the new one
+// writerOnly hides any ReadFrom method dst might have (e.g. *net.TCPConn's),
+// forcing io.CopyBuffer to actually use the pooled buffer below instead of
+// falling back to its own per-call allocation via genericReadFrom.
+type writerOnly struct {
+	io.Writer
 }
 
 func pipe(dst io.Writer, src io.Reader, wg *sync.WaitGroup) {
 	defer func() {
 		wg.Done()
 		if r := recover(); r != nil {
-			log.Println("Recovered in", r, ":", string(debug.Stack()))
+			errorf("recovered in pipe: %v\n%s", r, debug.Stack())
 		}
 	}()
 	wg.Add(1)
-	_, err := io.Copy(dst, src)
-	// handle error
-	log.Println(err)
-}
-
-// TCPServer is handler for all tcp queries
-func TCPServer(l net.Listener) {
-	defer l.Close()
-	for {
-		// Wait for a connection.
-		conn, err := l.Accept()
-		if err != nil {
-			log.Fatal(err)
-		}
-		// Handle the connection in a new goroutine.
-		// The loop then returns to accepting, so that
-		// multiple connections may be served concurrently.
-		go func(c net.Conn) {
-			defer func() {
-				if r := recover(); r != nil {
-					log.Println("Recovered in", r, ":", string(debug.Stack()))
-				}
-			}()
-			defer c.Close()
-
-			// TODO: binary mode if first byte is 0x00
-
-			rdr := bufio.NewReader(c)
-			// Read first line
-			line, isPrefix, err := rdr.ReadLine()
-			if err != nil || isPrefix {
-				// handle error
-				log.Panicln(err)
-			}
-
-			// Try to check cache
-			addr, ok := readBackendAddrCache(string(line))
-			if !ok {
-				// Try to decode it (base64)
-				data, err := base64.StdEncoding.DecodeString(string(line))
-				if err != nil {
-					log.Panicln("error:", err)
-					return
-				}
-
-				// Try to decrypt it (AES)
-				block, err := aes.NewCipher(_SecretPassphase)
-				if err != nil {
-					log.Panicln("error:", err)
-				}
-				if len(data) < aes.BlockSize {
-					log.Panicln("error:", errors.New("ciphertext too short"))
-				}
-				iv := data[:aes.BlockSize]
-				text := data[aes.BlockSize:]
-				cfb := cipher.NewCFBDecrypter(block, iv)
-				cfb.XORKeyStream(text, text)
-
-				// Check and remove the salt
-				if len(text) < len(_Salt) {
-					log.Panicln("error:", errors.New("salt check failed"))
-				}
-
-				addrLength := len(text) - len(_Salt)
-				if !bytes.Equal(text[addrLength:], _Salt) {
-					log.Panicln("error:", errors.New("salt not match"))
-				}
-
-				addr = string(text[:addrLength])
-
-				// Write to cache
-				writeBackendAddrCache(string(line), addr)
-			}
-
-			// Build tunnel
-			backend, err := net.Dial("tcp", addr)
-			if err != nil {
-				// handle error
-				log.Panicln(err)
-			}
-			defer backend.Close()
 
-			// Start transfering data
-			var wg sync.WaitGroup
+	buf := bufferPool.Get().([]byte)
+	defer bufferPool.Put(buf)
 
-			go pipe(c, backend, &wg)
-			go pipe(backend, c, &wg)
+	if _, err := io.CopyBuffer(writerOnly{dst}, src, buf); err != nil && !isClosedConnError(err) {
+		warnf("relay: %v", err)
+	}
+}
 
-			wg.Wait()
-			// handle error
-			log.Panicln(err)
+// buildListeners resolves the set of ListenerConfig to run: the contents of
+// -config if given, otherwise a single listener built from the flags (with
+// SECRET/SALT/CIPHER env vars as a fallback, for compatibility with
+// pre-config deployments).
+func buildListeners(configPath, listen, secret, salt, cipherName string, enableUDP bool) ([]ListenerConfig, error) {
+	if configPath != "" {
+		return loadConfig(configPath)
+	}
 
-		}(conn)
+	if secret == "" {
+		secret = os.Getenv("SECRET")
 	}
+	if salt == "" {
+		salt = os.Getenv("SALT")
+	}
+	if name := os.Getenv("CIPHER"); name != "" {
+		cipherName = name
+	}
+
+	return []ListenerConfig{{
+		Listen: listen,
+		Cipher: cipherName,
+		Secret: secret,
+		Salt:   salt,
+		UDP:    enableUDP,
+	}}, nil
 }
 
 func main() {
+	configPath := flag.String("config", "", "path to a JSON config file listing listeners (overrides the other flags)")
+	listen := flag.String("listen", _DefaultListen, "address to listen on, e.g. :4043")
+	secret := flag.String("secret", "", "AES/ChaCha20 key; falls back to $SECRET")
+	salt := flag.String("salt", "", "shared salt appended to the address before encryption; falls back to $SALT")
+	cipherName := flag.String("cipher", _DefaultCipher, "address/data cipher: aes-128-cfb, aes-256-cfb, aes-128-ctr, aes-256-ctr, chacha20-ietf")
+	timeoutSeconds := flag.Int("timeout", 0, "idle timeout, in seconds, for relayed connections (0 disables it)")
+	enableUDP := flag.Bool("udp", false, "also relay UDP datagrams on the same port")
+	logLevel := flag.String("log-level", "info", "log verbosity: debug, info, warn, or error")
+	bufferSize := flag.Int("buffer-size", _DefaultBufferSize, "size, in bytes, of the pooled buffers used to relay data")
+	flag.Parse()
+
+	_BufferSize = *bufferSize
+	_MinLogLevel = parseLogLevel(*logLevel)
+
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	os.Setenv("GOTRACEBACK", "crash")
 
@@ -181,13 +97,40 @@ func main() {
 		syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lim)
 	}
 
-	_Salt = []byte(os.Getenv("SALT"))
-	_SecretPassphase = []byte(os.Getenv("SECRET"))
-
-	ln, err := net.Listen("tcp", ":"+_DefaultPort)
+	listeners, err := buildListeners(*configPath, *listen, *secret, *salt, *cipherName, *enableUDP)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if len(listeners) == 0 {
+		log.Fatal("no listeners configured")
+	}
+
+	timeout := time.Duration(*timeoutSeconds) * time.Second
+
+	var wg sync.WaitGroup
+	for _, lc := range listeners {
+		c, err := NewCipher(lc.Cipher)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		srv := &Server{
+			Listen:  lc.Listen,
+			Cipher:  c,
+			Secret:  []byte(lc.Secret),
+			Salt:    []byte(lc.Salt),
+			OTA:     lc.OTA,
+			UDP:     lc.UDP,
+			Timeout: timeout,
+		}
 
-	TCPServer(ln)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.ListenAndServe(); err != nil {
+				errorf("listener %s stopped: %v", srv.Listen, err)
+			}
+		}()
+	}
+	wg.Wait()
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// idleReader resets conn's read deadline before every Read, so a fixed
+// deadline acts as a sliding idle timeout across the life of a tunnel
+// instead of an absolute one. A zero timeout disables this (no deadline is
+// set). r is the actual source of bytes: usually conn itself, but it can be
+// a bufio.Reader wrapping conn, so callers that already consumed some of
+// conn's data through a buffered reader (e.g. reading the address header)
+// don't lose whatever that reader buffered past it.
+type idleReader struct {
+	r       io.Reader
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r idleReader) Read(p []byte) (int, error) {
+	if r.timeout > 0 {
+		r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	}
+	return r.r.Read(p)
+}
+
+// idleWriter is idleReader's write-side counterpart: it resets conn's write
+// deadline before every Write, so a peer that stops reading (and so blocks
+// our writes indefinitely) gets dropped like any other idle connection
+// instead of pinning a goroutine forever. A zero timeout disables this.
+type idleWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w idleWriter) Write(p []byte) (int, error) {
+	if w.timeout > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	}
+	return w.conn.Write(p)
+}
+
+// isClosedConnError reports whether err is the routine "the other side (or
+// we) closed the connection" kind of error a relay sees on every normal
+// connection teardown, as opposed to something worth logging.
+func isClosedConnError(err error) bool {
+	if err == nil || errors.Is(err, io.EOF) {
+		return true
+	}
+	return strings.Contains(err.Error(), "use of closed network connection")
+}
+
+// isTemporaryNetError reports whether a listener Accept error is worth
+// retrying (e.g. a momentary fd exhaustion) rather than fatal to the
+// listener.
+func isTemporaryNetError(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Temporary()
+}
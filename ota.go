@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	// _AddrTypeOTA is ORed into the address-type prefix byte to turn on
+	// one-time auth for a connection, shadowsocks-OTA style.
+	_AddrTypeOTA byte = 0x80
+
+	// otaHMACSize is how many bytes of each HMAC-SHA1 are kept on the wire,
+	// for both the address header and the per-chunk data framing.
+	otaHMACSize = 10
+)
+
+// otaAddrHMAC authenticates the address header: HMAC-SHA1(iv+key, addr)[:10].
+func otaAddrHMAC(iv, key, addr []byte) []byte {
+	mac := hmac.New(sha1.New, append(append([]byte{}, iv...), key...))
+	mac.Write(addr)
+	return mac.Sum(nil)[:otaHMACSize]
+}
+
+// otaChunkHMAC authenticates one data chunk: HMAC-SHA1(iv+chunkIndex, payload)[:10].
+func otaChunkHMAC(iv []byte, chunkIndex uint32, payload []byte) []byte {
+	idx := make([]byte, 4)
+	binary.BigEndian.PutUint32(idx, chunkIndex)
+	mac := hmac.New(sha1.New, append(append([]byte{}, iv...), idx...))
+	mac.Write(payload)
+	return mac.Sum(nil)[:otaHMACSize]
+}
+
+// otaChunkReader unwraps the `len(2) | HMAC-SHA1(iv+chunkIndex, payload)[:10] | payload`
+// framing OTA mode adds to the client->backend data channel, verifying each
+// chunk's HMAC before handing its payload to the caller. A failed HMAC is a
+// fatal read error, which pipe propagates and tears the connection down.
+type otaChunkReader struct {
+	r          io.Reader
+	iv         []byte
+	chunkIndex uint32
+	pending    []byte
+}
+
+func newOTAChunkReader(r io.Reader, iv []byte) *otaChunkReader {
+	return &otaChunkReader{r: r, iv: iv}
+}
+
+func (o *otaChunkReader) Read(p []byte) (int, error) {
+	if len(o.pending) == 0 {
+		if err := o.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, o.pending)
+	o.pending = o.pending[n:]
+	return n, nil
+}
+
+func (o *otaChunkReader) readChunk() error {
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(o.r, lenBuf[:]); err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint16(lenBuf[:])
+
+	mac := make([]byte, otaHMACSize)
+	if _, err := io.ReadFull(o.r, mac); err != nil {
+		return err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(o.r, payload); err != nil {
+		return err
+	}
+
+	if !hmac.Equal(mac, otaChunkHMAC(o.iv, o.chunkIndex, payload)) {
+		return ErrOTAAuthFailed
+	}
+
+	o.chunkIndex++
+	o.pending = payload
+	return nil
+}
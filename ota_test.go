@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func buildOTAChunk(iv []byte, chunkIndex uint32, payload []byte) []byte {
+	var buf bytes.Buffer
+	var lenBuf [2]byte
+	binary.BigEndian.PutUint16(lenBuf[:], uint16(len(payload)))
+	buf.Write(lenBuf[:])
+	buf.Write(otaChunkHMAC(iv, chunkIndex, payload))
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+func TestOTAChunkReaderAcceptsValidChunks(t *testing.T) {
+	iv := []byte("0123456789012345")
+	chunks := [][]byte{[]byte("hello"), []byte("world!")}
+
+	var wire bytes.Buffer
+	for i, c := range chunks {
+		wire.Write(buildOTAChunk(iv, uint32(i), c))
+	}
+
+	r := newOTAChunkReader(&wire, iv)
+	for _, want := range chunks {
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(r, got); err != nil {
+			t.Fatalf("ReadFull: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestOTAChunkReaderRejectsTamperedChunk(t *testing.T) {
+	iv := []byte("0123456789012345")
+	wire := buildOTAChunk(iv, 0, []byte("hello"))
+	wire[len(wire)-1] ^= 0xff // flip a payload byte without updating the HMAC
+
+	r := newOTAChunkReader(bytes.NewReader(wire), iv)
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(r, buf); err != ErrOTAAuthFailed {
+		t.Fatalf("got err %v, want ErrOTAAuthFailed", err)
+	}
+}
+
+func TestOTAChunkReaderRejectsWrongChunkIndex(t *testing.T) {
+	iv := []byte("0123456789012345")
+	// HMAC was computed for chunk index 1, but the reader starts at 0.
+	wire := buildOTAChunk(iv, 1, []byte("hello"))
+
+	r := newOTAChunkReader(bytes.NewReader(wire), iv)
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(r, buf); err != ErrOTAAuthFailed {
+		t.Fatalf("got err %v, want ErrOTAAuthFailed", err)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"encoding/base64"
+	"io"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Server runs the TCP (and optionally UDP) relay for one listener, with its
+// own cipher/secret/salt/OTA configuration, so several Servers can run
+// concurrently in one process, each fronting a different backend under
+// different credentials.
+type Server struct {
+	Listen  string
+	Cipher  Cipher
+	Secret  []byte
+	Salt    []byte
+	OTA     bool
+	UDP     bool
+	Timeout time.Duration
+}
+
+// decodedHeader is the result of decoding a client's address header: the
+// resolved backend address, whether OTA is in effect for the connection, and
+// the IV that was used, which the OTA chunk verifier also needs as key
+// material for the data channel.
+type decodedHeader struct {
+	Addr string
+	OTA  bool
+	IV   []byte
+}
+
+// decodeBackendAddr decrypts the backend address carried by an encrypted
+// header line: base64(iv || cipher(addrType | addr [| HMAC-SHA1(iv+key, addr)[:10]
+// if OTA] | salt)), with the IV sized to whatever s.Cipher requires; shared
+// by TCPServer and UDPServer since both speak the same framing for the first
+// packet of a session.
+//
+// There is deliberately no cache here: this request put a random IV in every
+// header, so the same address never produces the same line twice and a
+// cache keyed on the line would never hit in practice while still paying to
+// grow and copy itself on every connection.
+func (s *Server) decodeBackendAddr(line []byte) (decodedHeader, error) {
+	data, err := base64.StdEncoding.DecodeString(string(line))
+	if err != nil {
+		return decodedHeader{}, err
+	}
+
+	ivSize := s.Cipher.IVSize()
+	if len(data) < ivSize {
+		return decodedHeader{}, ErrShortCiphertext
+	}
+	iv := data[:ivSize]
+	text := data[ivSize:]
+
+	stream, err := s.Cipher.Decrypter(s.Secret, iv)
+	if err != nil {
+		return decodedHeader{}, err
+	}
+	stream.XORKeyStream(text, text)
+
+	// Check and remove the salt
+	if len(text) < 1+len(s.Salt) {
+		return decodedHeader{}, ErrShortHeader
+	}
+
+	addrType := text[0]
+	ota := addrType&_AddrTypeOTA != 0
+	body := text[1 : len(text)-len(s.Salt)]
+
+	if !bytes.Equal(text[len(text)-len(s.Salt):], s.Salt) {
+		return decodedHeader{}, ErrSaltMismatch
+	}
+
+	if ota {
+		if len(body) < otaHMACSize {
+			return decodedHeader{}, ErrOTAAuthFailed
+		}
+		addrEnd := len(body) - otaHMACSize
+		mac := body[addrEnd:]
+		body = body[:addrEnd]
+		if !hmac.Equal(mac, otaAddrHMAC(iv, s.Secret, body)) {
+			return decodedHeader{}, ErrOTAAuthFailed
+		}
+	} else if s.OTA {
+		return decodedHeader{}, ErrOTARequired
+	}
+
+	addr := string(body)
+
+	return decodedHeader{Addr: addr, OTA: ota, IV: iv}, nil
+}
+
+// ListenAndServe opens this listener's TCP socket (and, if s.UDP is set, a
+// UDP socket on the same address) and serves until the TCP accept loop
+// returns an error.
+func (s *Server) ListenAndServe() error {
+	if s.UDP {
+		uconn, err := s.listenUDP()
+		if err != nil {
+			return err
+		}
+		go s.UDPServer(uconn)
+	}
+
+	ln, err := net.Listen("tcp", s.Listen)
+	if err != nil {
+		return err
+	}
+
+	s.TCPServer(ln)
+	return nil
+}
+
+func (s *Server) listenUDP() (*net.UDPConn, error) {
+	host, portStr, err := net.SplitHostPort(s.Listen)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(host), Port: port})
+}
+
+// TCPServer is handler for all tcp queries. A per-connection error (bad
+// header, dial failure, timeout) is logged and that connection is dropped;
+// it never brings down the accept loop.
+func (s *Server) TCPServer(l net.Listener) {
+	defer l.Close()
+	for {
+		// Wait for a connection.
+		conn, err := l.Accept()
+		if err != nil {
+			if isTemporaryNetError(err) {
+				warnf("accept on %s: %v", s.Listen, err)
+				continue
+			}
+			errorf("accept on %s: %v, listener shutting down", s.Listen, err)
+			return
+		}
+		// Handle the connection in a new goroutine.
+		// The loop then returns to accepting, so that
+		// multiple connections may be served concurrently.
+		go s.handleTCPConn(conn)
+	}
+}
+
+// handleTCPConn decodes one connection's address header, dials the backend
+// and relays data between the two until either side closes or, under OTA,
+// a chunk fails to authenticate.
+func (s *Server) handleTCPConn(c net.Conn) {
+	defer func() {
+		if r := recover(); r != nil {
+			errorf("recovered in TCP handler for %s: %v\n%s", c.RemoteAddr(), r, debug.Stack())
+		}
+	}()
+	defer c.Close()
+
+	// TODO: binary mode if first byte is 0x00
+
+	if s.Timeout > 0 {
+		c.SetReadDeadline(time.Now().Add(s.Timeout))
+	}
+
+	rdr := bufio.NewReader(c)
+	// Read first line
+	line, isPrefix, err := rdr.ReadLine()
+	if err != nil || isPrefix {
+		warnf("read address header from %s: %v", c.RemoteAddr(), err)
+		return
+	}
+
+	// Try to decode the backend address (cache, then base64+cipher+salt)
+	header, err := s.decodeBackendAddr(line)
+	if err != nil {
+		warnf("decode address header from %s: %v", c.RemoteAddr(), err)
+		return
+	}
+
+	// Build tunnel
+	backend, err := net.Dial("tcp", header.Addr)
+	if err != nil {
+		warnf("%v: %s: %v", ErrBackendDial, header.Addr, err)
+		return
+	}
+	defer backend.Close()
+
+	// Start transfering data
+	var wg sync.WaitGroup
+
+	// idleReader resets the read deadline on every Read, turning the fixed
+	// deadline above into a sliding idle timeout for the life of the tunnel.
+	// It reads through rdr, not c directly, so any data bytes rdr already
+	// buffered past the address header (e.g. because the client sent the
+	// header and its first payload in one write) aren't lost.
+	var fromClient io.Reader = idleReader{r: rdr, conn: c, timeout: s.Timeout}
+	if header.OTA {
+		// When OTA is in effect, every data chunk the client sends must
+		// carry a valid HMAC; drop the session the moment one doesn't.
+		fromClient = newOTAChunkReader(fromClient, header.IV)
+	}
+	fromBackend := idleReader{r: backend, conn: backend, timeout: s.Timeout}
+
+	toBackend := idleWriter{conn: backend, timeout: s.Timeout}
+	toClient := idleWriter{conn: c, timeout: s.Timeout}
+
+	go pipe(toBackend, fromClient, &wg)
+	go pipe(toClient, fromBackend, &wg)
+
+	wg.Wait()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	_UDPBufferSize  = 64 * 1024
+	_UDPIdleTimeout = 60 * time.Second
+)
+
+// udpSession is one client<->backend relay, keyed by the client's (addr, port).
+// The first datagram from a client carries the encrypted destination header
+// (matching the TCP framing); every datagram after that is relayed verbatim.
+type udpSession struct {
+	backend    *net.UDPConn
+	lastActive atomic.Value // time.Time
+}
+
+func (s *udpSession) touch() {
+	s.lastActive.Store(time.Now())
+}
+
+func (s *udpSession) idle(timeout time.Duration) bool {
+	return time.Since(s.lastActive.Load().(time.Time)) > timeout
+}
+
+// UDPServer relays UDP datagrams between clients and the backend resolved
+// from each session's address header, mirroring TCPServer's framing but
+// tracking sessions by client address since UDP has no connection state.
+func (s *Server) UDPServer(conn *net.UDPConn) {
+	defer conn.Close()
+
+	var sessions sync.Map // string(client addr) -> *udpSession
+
+	go reapUDPSessions(&sessions, _UDPIdleTimeout)
+
+	buf := make([]byte, _UDPBufferSize)
+	for {
+		n, clientAddr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if isTemporaryNetError(err) {
+				warnf("udp read on %s: %v", s.Listen, err)
+				continue
+			}
+			errorf("udp read on %s: %v, listener shutting down", s.Listen, err)
+			return
+		}
+
+		key := clientAddr.String()
+		if v, ok := sessions.Load(key); ok {
+			session := v.(*udpSession)
+			session.touch()
+			if _, err := session.backend.Write(buf[:n]); err != nil {
+				warnf("udp write to backend for %s: %v", key, err)
+			}
+			continue
+		}
+
+		// First packet of a session: decode the destination header and dial
+		// the backend.
+		header, err := s.decodeBackendAddr(buf[:n])
+		if err != nil {
+			warnf("decode udp address header from %s: %v", key, err)
+			continue
+		}
+
+		backendAddr, err := net.ResolveUDPAddr("udp", header.Addr)
+		if err != nil {
+			warnf("resolve udp backend %s: %v", header.Addr, err)
+			continue
+		}
+
+		backend, err := net.DialUDP("udp", nil, backendAddr)
+		if err != nil {
+			warnf("%v: %s: %v", ErrBackendDial, header.Addr, err)
+			continue
+		}
+
+		session := &udpSession{backend: backend}
+		session.touch()
+		sessions.Store(key, session)
+
+		go relayUDPBackend(conn, clientAddr, &sessions, key, session)
+	}
+}
+
+// relayUDPBackend copies datagrams from a session's backend connection back
+// to the client until the backend goes away or falls idle.
+func relayUDPBackend(conn *net.UDPConn, clientAddr *net.UDPAddr, sessions *sync.Map, key string, session *udpSession) {
+	defer func() {
+		if r := recover(); r != nil {
+			errorf("recovered in udp backend relay for %s: %v\n%s", clientAddr, r, debug.Stack())
+		}
+	}()
+	defer func() {
+		sessions.Delete(key)
+		session.backend.Close()
+	}()
+
+	buf := make([]byte, _UDPBufferSize)
+	for {
+		session.backend.SetReadDeadline(time.Now().Add(_UDPIdleTimeout))
+		n, err := session.backend.Read(buf)
+		if err != nil {
+			return
+		}
+		session.touch()
+		if _, err := conn.WriteToUDP(buf[:n], clientAddr); err != nil {
+			warnf("udp write to client %s: %v", clientAddr, err)
+			return
+		}
+	}
+}
+
+// reapUDPSessions periodically closes sessions that have seen no traffic in
+// timeout, so a misbehaving or vanished client doesn't leak a backend conn.
+func reapUDPSessions(sessions *sync.Map, timeout time.Duration) {
+	ticker := time.NewTicker(timeout)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		sessions.Range(func(key, value interface{}) bool {
+			session := value.(*udpSession)
+			if session.idle(timeout) {
+				sessions.Delete(key)
+				session.backend.Close()
+			}
+			return true
+		})
+	}
+}